@@ -17,32 +17,147 @@
 package testing
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
 	"github.com/ethereum/go-ethereum/swarm/network"
 	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/ethereum/go-ethereum/swarm/storage/mock"
+	"github.com/ethereum/go-ethereum/swarm/storage/mock/db"
 )
 
 type Simulation struct {
-	Net    *simulations.Network
-	Stores []storage.ChunkStore
-	Addrs  []network.Addr
-	IDs    []discover.NodeID
+	Net   *simulations.Network
+	Addrs []network.Addr
+	IDs   []discover.NodeID
+
+	buckets   map[discover.NodeID]*sync.Map
+	bucketsMu sync.Mutex
+
+	monitor *runMonitor
+}
+
+// bucket returns the per-node bucket for id, creating it on first use.
+func (s *Simulation) bucket(id discover.NodeID) *sync.Map {
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+	b, ok := s.buckets[id]
+	if !ok {
+		b = new(sync.Map)
+		s.buckets[id] = b
+	}
+	return b
+}
+
+// NodeItem returns an item previously stored for node id under key by
+// SetNodeItem. It is used by Step actions and checks to retrieve state
+// that a service stashed away at construction time, such as a node's
+// LocalStore, NetStore, Delivery or Registry, without resorting to
+// global maps keyed by node.
+func (s *Simulation) NodeItem(id discover.NodeID, key interface{}) (value interface{}, ok bool) {
+	return s.bucket(id).Load(key)
+}
+
+// SetNodeItem stores an item for node id under key so it can be
+// retrieved later with NodeItem.
+func (s *Simulation) SetNodeItem(id discover.NodeID, key interface{}, value interface{}) {
+	s.bucket(id).Store(key, value)
+}
+
+// bucketKey is a private type for keys NewSimulation and its helpers
+// store in a node's bucket, so they can't collide with the arbitrary
+// caller-supplied keys NodeItem/SetNodeItem otherwise accept.
+type bucketKey int
+
+// bucketKeyStore is the bucket key under which NewSimulation stashes
+// each node's ChunkStore, replacing the old parallel Simulation.Stores
+// slice with the same per-node bucket used by services.
+const bucketKeyStore bucketKey = iota
+
+// NodeStore returns the ChunkStore stashed for node id by NewSimulation.
+func (s *Simulation) NodeStore(id discover.NodeID) storage.ChunkStore {
+	v, _ := s.NodeItem(id, bucketKeyStore)
+	store, _ := v.(storage.ChunkStore)
+	return store
+}
+
+// BucketServiceFunc is an adapters.ServiceFunc that also receives the
+// bucket of the node it is constructing the service for, so the service
+// can stash state for later retrieval via Simulation.NodeItem.
+type BucketServiceFunc func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, error)
+
+// Services maps service names to BucketServiceFunc, mirroring
+// adapters.Services but with bucket access threaded through.
+type Services map[string]BucketServiceFunc
+
+// wrapServices adapts Services to adapters.Services, resolving the
+// bucket for each node from s as services are constructed.
+func wrapServices(services Services, s *Simulation) adapters.Services {
+	wrapped := make(adapters.Services, len(services))
+	for name, serviceFunc := range services {
+		serviceFunc := serviceFunc
+		wrapped[name] = func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return serviceFunc(ctx, s.bucket(ctx.Config.ID))
+		}
+	}
+	return wrapped
+}
+
+// StoreType selects the ChunkStore backend that SetStores hands out to
+// simulated nodes.
+type StoreType string
+
+const (
+	// StoreTypeLDB backs each node with its own on-disk LevelDB store,
+	// same as a real node. This is the default.
+	StoreTypeLDB StoreType = "ldb"
+	// StoreTypeMem backs each node with an in-memory store, for fast
+	// unit test runs that don't care about persistence.
+	StoreTypeMem StoreType = "mem"
+	// StoreTypeMock backs every node with the same shared mock store,
+	// keyed by node address, so large-scale simulations don't exhaust
+	// file descriptors or disk creating one LDBStore per node.
+	StoreTypeMock StoreType = "mock"
+)
+
+// StoreConfig configures the ChunkStore backend used by SetStores.
+type StoreConfig struct {
+	Type StoreType
+	// GlobalStore is the shared store nodes read and write through when
+	// Type is StoreTypeMock. If nil, SetStores creates one backed by a
+	// LevelDB database in a temporary directory.
+	GlobalStore mock.GlobalStorer
 }
 
-func SetStores(addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
+func SetStores(conf StoreConfig, addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
+	switch conf.Type {
+	case StoreTypeMem:
+		return setMemStores(addrs...)
+	case StoreTypeMock:
+		return setMockStores(conf.GlobalStore, addrs...)
+	default:
+		return setLDBStores(addrs...)
+	}
+}
+
+func setLDBStores(addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
 	var datadirs []string
 	stores := make([]storage.ChunkStore, len(addrs))
 	var err error
@@ -68,6 +183,43 @@ func SetStores(addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
 	return stores, teardown, err
 }
 
+func setMemStores(addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
+	stores := make([]storage.ChunkStore, len(addrs))
+	for i := range addrs {
+		stores[i] = storage.NewMapChunkStore()
+	}
+	return stores, func() {}, nil
+}
+
+// setMockStores hands every node a NodeStore reading and writing through
+// globalStore, keyed by the node's own address. If globalStore is nil, a
+// fresh one backed by a temporary LevelDB database is created and torn down
+// with the returned teardown func.
+func setMockStores(globalStore mock.GlobalStorer, addrs ...network.Addr) ([]storage.ChunkStore, func(), error) {
+	teardown := func() {}
+	if globalStore == nil {
+		datadir, err := ioutil.TempDir("", "swarm-mock-store")
+		if err != nil {
+			return nil, teardown, err
+		}
+		gs, err := db.NewGlobalStore(filepath.Join(datadir, "mock.db"))
+		if err != nil {
+			os.RemoveAll(datadir)
+			return nil, teardown, err
+		}
+		teardown = func() {
+			gs.Close()
+			os.RemoveAll(datadir)
+		}
+		globalStore = gs
+	}
+	stores := make([]storage.ChunkStore, len(addrs))
+	for i, addr := range addrs {
+		stores[i] = globalStore.NewNodeStore(common.BytesToAddress(addr.Over()))
+	}
+	return stores, teardown, nil
+}
+
 func NewAdapter(adapterType string, services adapters.Services) (adapter adapters.NodeAdapter, teardown func(), err error) {
 	teardown = func() {}
 	switch adapterType {
@@ -93,37 +245,407 @@ func NewAdapter(adapterType string, services adapters.Services) (adapter adapter
 	return adapter, teardown, nil
 }
 
-func CheckResult(t *testing.T, result *simulations.StepResult, startedAt, finishedAt time.Time) {
-	t.Logf("Simulation with %d nodes passed in %s", len(result.Passes), result.FinishedAt.Sub(result.StartedAt))
-	var min, max time.Duration
+// SimulationEvent records a single connect/disconnect/message event
+// observed on the simulated network while a Step was running.
+type SimulationEvent struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Type      string          `json:"type"` // "connect", "disconnect" or "msg"
+	Node      discover.NodeID `json:"node"`
+	Peer      discover.NodeID `json:"peer,omitempty"`
+	Code      *uint64         `json:"code,omitempty"`
+}
+
+// ResourceSample is a point-in-time snapshot of process resource usage
+// taken at a fixed interval while a Step was running.
+type ResourceSample struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Goroutines int       `json:"goroutines"`
+	HeapAlloc  uint64    `json:"heap_alloc"`
+}
+
+// SimulationReport captures detailed diagnostics for a single Run, in
+// place of the min/max/avg summary CheckResult used to only log, so a
+// failing or stalled step can be bisected after the fact.
+type SimulationReport struct {
+	NodeCount     int                           `json:"node_count"`
+	StartedAt     time.Time                     `json:"started_at"`
+	FinishedAt    time.Time                     `json:"finished_at"`
+	Passes        map[discover.NodeID]time.Time `json:"passes"`
+	Min           time.Duration                 `json:"min"`
+	Max           time.Duration                 `json:"max"`
+	Avg           time.Duration                 `json:"avg"`
+	Events        []SimulationEvent             `json:"events"`
+	MessageCounts map[uint64]int                `json:"message_counts"`
+	Samples       []ResourceSample              `json:"samples"`
+}
+
+// write marshals the report as JSON to path, and the report's events as
+// a newline-delimited JSON log to path+".events.ndjson", suitable for
+// post-hoc analysis or replay in the p2p simulations HTTP frontend.
+func (r *SimulationReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range r.Events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path+".events.ndjson", buf.Bytes(), 0644)
+}
+
+// runMonitor collects connect/disconnect/message events and periodic
+// resource samples observed on a Simulation's network during a Run.
+type runMonitor struct {
+	mu            sync.Mutex
+	events        []SimulationEvent
+	messageCounts map[uint64]int
+	samples       []ResourceSample
+}
+
+func newRunMonitor() *runMonitor {
+	return &runMonitor{messageCounts: make(map[uint64]int)}
+}
+
+// resourceSampleInterval is how often runMonitor samples goroutine and
+// heap usage while a Step is running.
+const resourceSampleInterval = time.Second
+
+func (m *runMonitor) watchEvents(ctx context.Context, net *simulations.Network) {
+	events := make(chan *simulations.Event)
+	sub := net.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case ev := <-events:
+			m.record(ev)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *runMonitor) record(ev *simulations.Event) {
+	var event SimulationEvent
+	switch ev.Type {
+	case simulations.EventTypeConn:
+		event = SimulationEvent{Timestamp: time.Now(), Node: ev.Conn.One, Peer: ev.Conn.Other}
+		if ev.Conn.Up {
+			event.Type = "connect"
+		} else {
+			event.Type = "disconnect"
+		}
+	case simulations.EventTypeMsg:
+		code := ev.Msg.Code
+		event = SimulationEvent{Timestamp: time.Now(), Type: "msg", Node: ev.Msg.One, Peer: ev.Msg.Other, Code: &code}
+	default:
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	if event.Code != nil {
+		m.messageCounts[*event.Code]++
+	}
+}
+
+func (m *runMonitor) sampleResources(ctx context.Context) {
+	ticker := time.NewTicker(resourceSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			m.mu.Lock()
+			m.samples = append(m.samples, ResourceSample{
+				Timestamp:  time.Now(),
+				Goroutines: runtime.NumGoroutine(),
+				HeapAlloc:  ms.HeapAlloc,
+			})
+			m.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// report builds a SimulationReport from the events and samples collected
+// so far, together with result's per-node pass times.
+func (m *runMonitor) report(nodeCount int, result *simulations.StepResult, startedAt, finishedAt time.Time) *SimulationReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := &SimulationReport{
+		NodeCount:     nodeCount,
+		StartedAt:     startedAt,
+		FinishedAt:    finishedAt,
+		Passes:        result.Passes,
+		Events:        m.events,
+		MessageCounts: m.messageCounts,
+		Samples:       m.samples,
+	}
 	var sum int
 	for _, pass := range result.Passes {
 		duration := pass.Sub(result.StartedAt)
-		if sum == 0 || duration < min {
-			min = duration
+		if sum == 0 || duration < report.Min {
+			report.Min = duration
 		}
-		if duration > max {
-			max = duration
+		if duration > report.Max {
+			report.Max = duration
 		}
 		sum += int(duration.Nanoseconds())
 	}
-	t.Logf("Min: %s, Max: %s, Average: %s", min, max, time.Duration(sum/len(result.Passes))*time.Nanosecond)
+	if len(result.Passes) > 0 {
+		report.Avg = time.Duration(sum/len(result.Passes)) * time.Nanosecond
+	}
+	return report
+}
+
+// CheckResult logs a summary of result and, if conf.ReportPath is set,
+// writes the full SimulationReport collected during s.Run as JSON. s.monitor
+// is only set once Run reaches the point of starting it, so CheckResult
+// falls back to an empty report if Run returned an error before then.
+func CheckResult(t *testing.T, s *Simulation, conf *RunConfig, result *simulations.StepResult, startedAt, finishedAt time.Time) {
+	monitor := s.monitor
+	if monitor == nil {
+		monitor = newRunMonitor()
+	}
+	report := monitor.report(len(s.IDs), result, startedAt, finishedAt)
+	t.Logf("Simulation with %d nodes passed in %s", len(result.Passes), result.FinishedAt.Sub(result.StartedAt))
+	t.Logf("Min: %s, Max: %s, Average: %s", report.Min, report.Max, report.Avg)
 	t.Logf("Setup: %s, Shutdown: %s", result.StartedAt.Sub(startedAt), finishedAt.Sub(result.FinishedAt))
+	if conf.ReportPath == "" {
+		return
+	}
+	if err := report.write(conf.ReportPath); err != nil {
+		t.Errorf("error writing simulation report: %s", err)
+	}
 }
 
 type RunConfig struct {
 	Adapter   string
 	Step      *simulations.Step
 	NodeCount int
-	ConnLevel int
 	ToAddr    func(discover.NodeID) *network.BzzAddr
-	Services  adapters.Services
+	Services  Services
+	// SnapshotPath, if set, is loaded instead of creating NodeCount fresh
+	// nodes, reproducing a specific topology across runs. It names a file
+	// holding a JSON-encoded *simulations.Snapshot. Mutually exclusive
+	// with Snapshot.
+	SnapshotPath string
+	// Snapshot, if set, is loaded the same way as SnapshotPath but is
+	// passed in already decoded. Mutually exclusive with SnapshotPath.
+	Snapshot *simulations.Snapshot
+	// WaitTillHealthy, if true, makes Run block on Simulation.WaitTillHealthy
+	// after connecting the nodes and before executing Step, so the
+	// kademlia tables have settled before the step starts sending
+	// messages.
+	WaitTillHealthy bool
+	// KadMinProxSize is the minimum proximity bin size passed to
+	// WaitTillHealthy when WaitTillHealthy is true.
+	KadMinProxSize int
+	// StoreType selects the ChunkStore backend used for the simulated
+	// nodes. It defaults to StoreTypeLDB.
+	StoreType StoreType
+	// GlobalStore is used as the shared store when StoreType is
+	// StoreTypeMock. If nil, one backed by a temporary file is created.
+	GlobalStore mock.GlobalStorer
+	// ReportPath, if set, makes CheckResult write the SimulationReport
+	// collected during Run as JSON to this path.
+	ReportPath string
+	// Topology wires the simulated nodes together in Run. If nil, it
+	// defaults to a ChainTopology.
+	Topology Topology
+}
+
+// Topology determines which pairs of nodes Simulation.Connect wires
+// together, given the number of nodes in the simulation.
+type Topology interface {
+	// Edges returns the pairs of node indices, into a slice of length n,
+	// that should be connected.
+	Edges(n int) [][2]int
+}
+
+// ChainTopology connects node i to node i+1 for every i, forming a
+// single chain. It is the default topology used by Run.
+type ChainTopology struct{}
+
+func (ChainTopology) Edges(n int) [][2]int {
+	if n <= 1 {
+		return nil
+	}
+	edges := make([][2]int, 0, n-1)
+	for i := 1; i < n; i++ {
+		edges = append(edges, [2]int{i - 1, i})
+	}
+	return edges
+}
+
+// RingTopology is a ChainTopology with the two ends additionally
+// connected, forming a ring.
+type RingTopology struct{}
+
+func (RingTopology) Edges(n int) [][2]int {
+	edges := ChainTopology{}.Edges(n)
+	if n > 2 {
+		edges = append(edges, [2]int{n - 1, 0})
+	}
+	return edges
+}
+
+// StarTopology connects node 0 to every other node.
+type StarTopology struct{}
+
+func (StarTopology) Edges(n int) [][2]int {
+	if n <= 1 {
+		return nil
+	}
+	edges := make([][2]int, 0, n-1)
+	for i := 1; i < n; i++ {
+		edges = append(edges, [2]int{0, i})
+	}
+	return edges
+}
+
+// GridTopology arranges nodes in a grid with Cols columns and connects
+// each node to its right and lower neighbours. If n exceeds Rows*Cols,
+// Rows is grown to fit all n nodes rather than leaving any isolated.
+type GridTopology struct {
+	Rows, Cols int
+}
+
+func (t GridTopology) Edges(n int) [][2]int {
+	rows := t.Rows
+	if t.Cols > 0 {
+		if needed := (n + t.Cols - 1) / t.Cols; needed > rows {
+			rows = needed
+		}
+	}
+	var edges [][2]int
+	index := func(row, col int) int { return row*t.Cols + col }
+	for row := 0; row < rows; row++ {
+		for col := 0; col < t.Cols; col++ {
+			i := index(row, col)
+			if i >= n {
+				continue
+			}
+			if col+1 < t.Cols {
+				if j := index(row, col+1); j < n {
+					edges = append(edges, [2]int{i, j})
+				}
+			}
+			if row+1 < rows {
+				if j := index(row+1, col); j < n {
+					edges = append(edges, [2]int{i, j})
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// WattsStrogatz builds a Watts-Strogatz small-world topology: a ring
+// lattice where each node connects to its K nearest neighbours (K/2 on
+// each side, so K is expected to be even; an odd K is rounded up to the
+// next even number rather than silently truncated), with each edge
+// rewired to a uniformly random, distinct, not-yet-connected node with
+// probability Beta.
+type WattsStrogatz struct {
+	K    int
+	Beta float64
+}
+
+func (t WattsStrogatz) Edges(n int) [][2]int {
+	if n <= 1 {
+		return nil
+	}
+	k := t.K
+	if k%2 != 0 {
+		k++
+	}
+	// Clamp so the ring-neighbour loop below never wraps a node's own
+	// index back onto itself: a self-loop only happens once a distance d
+	// (1..k/2) reaches n, so the largest safe k is 2*(n-1).
+	if max := 2 * (n - 1); k > max {
+		k = max
+	}
+
+	edgeKey := func(i, j int) [2]int {
+		if i > j {
+			i, j = j, i
+		}
+		return [2]int{i, j}
+	}
+
+	connected := make(map[[2]int]bool)
+	var edges [][2]int
+	for i := 0; i < n; i++ {
+		for d := 1; d <= k/2; d++ {
+			j := (i + d) % n
+			if j == i {
+				continue
+			}
+			key := edgeKey(i, j)
+			if !connected[key] {
+				connected[key] = true
+				// keep the originating node first, so rewiring below
+				// keeps it and every node retains its own ring edges
+				edges = append(edges, [2]int{i, j})
+			}
+		}
+	}
+
+	for idx, edge := range edges {
+		if rand.Float64() >= t.Beta {
+			continue
+		}
+		i := edge[0]
+		for attempts := 0; attempts < n; attempts++ {
+			j := rand.Intn(n)
+			key := edgeKey(i, j)
+			if j == i || connected[key] {
+				continue
+			}
+			delete(connected, edgeKey(edge[0], edge[1]))
+			connected[key] = true
+			edges[idx] = key
+			break
+		}
+	}
+	return edges
+}
+
+// loadSnapshot resolves conf.SnapshotPath/conf.Snapshot into a
+// *simulations.Snapshot, reading and decoding it from disk if a path was
+// given. It returns nil, nil if neither was set.
+func loadSnapshot(path string, snapshot *simulations.Snapshot) (*simulations.Snapshot, error) {
+	if path == "" {
+		return snapshot, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot file: %s", err)
+	}
+	snap := &simulations.Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("error decoding snapshot file: %s", err)
+	}
+	return snap, nil
 }
 
 func NewSimulation(conf *RunConfig) (*Simulation, func(), error) {
 	// create network
 	nodes := conf.NodeCount
-	adapter, adapterTeardown, err := NewAdapter(conf.Adapter, conf.Services)
+	s := &Simulation{
+		buckets: make(map[discover.NodeID]*sync.Map),
+	}
+	adapter, adapterTeardown, err := NewAdapter(conf.Adapter, wrapServices(conf.Services, s))
 	if err != nil {
 		return nil, adapterTeardown, err
 	}
@@ -135,19 +657,38 @@ func NewSimulation(conf *RunConfig) (*Simulation, func(), error) {
 		adapterTeardown()
 		net.Shutdown()
 	}
-	ids := make([]discover.NodeID, nodes)
-	addrs := make([]network.Addr, nodes)
-	// start nodes
-	for i := 0; i < nodes; i++ {
-		node, err := net.NewNode()
-		if err != nil {
-			return nil, teardown, fmt.Errorf("error creating node: %s", err)
+
+	snap, err := loadSnapshot(conf.SnapshotPath, conf.Snapshot)
+	if err != nil {
+		return nil, teardown, err
+	}
+
+	var ids []discover.NodeID
+	var addrs []network.Addr
+	if snap != nil {
+		if err := net.Load(snap); err != nil {
+			return nil, teardown, fmt.Errorf("error loading snapshot: %s", err)
+		}
+		ids = net.GetNodeIDs()
+		addrs = make([]network.Addr, len(ids))
+		for i, id := range ids {
+			addrs[i] = conf.ToAddr(id)
+		}
+	} else {
+		ids = make([]discover.NodeID, nodes)
+		addrs = make([]network.Addr, nodes)
+		// start nodes
+		for i := 0; i < nodes; i++ {
+			n, err := net.NewNode()
+			if err != nil {
+				return nil, teardown, fmt.Errorf("error creating node: %s", err)
+			}
+			ids[i] = n.ID()
+			addrs[i] = conf.ToAddr(ids[i])
 		}
-		ids[i] = node.ID()
-		addrs[i] = conf.ToAddr(ids[i])
 	}
 	// set nodes number of Stores available
-	stores, storeTeardown, err := SetStores(addrs...)
+	stores, storeTeardown, err := SetStores(StoreConfig{Type: conf.StoreType, GlobalStore: conf.GlobalStore}, addrs...)
 	teardown = func() {
 		storeTeardown()
 		adapterTeardown()
@@ -156,45 +697,131 @@ func NewSimulation(conf *RunConfig) (*Simulation, func(), error) {
 	if err != nil {
 		return nil, teardown, err
 	}
-	s := &Simulation{
-		Net:    net,
-		Stores: stores,
-		IDs:    ids,
-		Addrs:  addrs,
+	s.Net = net
+	s.IDs = ids
+	s.Addrs = addrs
+	for i, id := range ids {
+		s.SetNodeItem(id, bucketKeyStore, stores[i])
 	}
 	return s, teardown, nil
 }
 
-func (s *Simulation) Run(conf *RunConfig) (*simulations.StepResult, error) {
-	// bring up nodes, launch the servive
-	nodes := conf.NodeCount
-	conns := conf.ConnLevel
-	for i := 0; i < nodes; i++ {
-		if err := s.Net.Start(s.IDs[i]); err != nil {
-			return nil, fmt.Errorf("error starting node %s: %s", s.IDs[i].TerminalString(), err)
-		}
-	}
-	// run a simulation which connects the 10 nodes in a chain
-	wg := sync.WaitGroup{}
-	for i := range s.IDs {
-		// collect the overlay addresses, to
-		for j := 0; j < conns; j++ {
-			var k int
-			if j == 0 {
-				k = i - 1
-			} else {
-				k = rand.Intn(len(s.IDs))
+// Snapshot serializes the current network topology to JSON, allowing it
+// to be fed back into RunConfig.Snapshot to reproduce this exact
+// topology in a later run.
+func (s *Simulation) Snapshot() ([]byte, error) {
+	snap, err := s.Net.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(snap)
+}
+
+// healthyPollInterval is how often WaitTillHealthy polls node health.
+const healthyPollInterval = 200 * time.Millisecond
+
+// WaitTillHealthy polls the hive_healthy RPC method of every node in the
+// simulation until each one reports a full, proven kademlia (GotNN,
+// KnowNN and Full all true) with the given minimum proximity bin size,
+// or until ctx expires. The last health snapshot is returned even on
+// timeout, so callers can diagnose which nodes never stabilized.
+func (s *Simulation) WaitTillHealthy(ctx context.Context, kadMinProxSize int) (map[discover.NodeID]*network.Health, error) {
+	ticker := time.NewTicker(healthyPollInterval)
+	defer ticker.Stop()
+
+	overAddrs := make([][]byte, len(s.Addrs))
+	for i, addr := range s.Addrs {
+		overAddrs[i] = addr.Over()
+	}
+	ppmap := network.NewPeerPotMap(kadMinProxSize, overAddrs)
+
+	for {
+		healths := make(map[discover.NodeID]*network.Health)
+		healthy := true
+		for i, id := range s.IDs {
+			simNode := s.Net.GetNode(id)
+			if simNode == nil {
+				return healths, fmt.Errorf("unknown node: %s", id)
 			}
-			if i > 0 {
-				wg.Add(1)
-				go func(i, k int) {
-					defer wg.Done()
-					s.Net.Connect(s.IDs[i], s.IDs[k])
-				}(i, k)
+			client, err := simNode.Client()
+			if err != nil {
+				return healths, fmt.Errorf("error getting node client: %s", err)
 			}
+			pp := ppmap[common.Bytes2Hex(s.Addrs[i].Over())]
+			health := &network.Health{}
+			if err := client.CallContext(ctx, health, "hive_healthy", pp); err != nil {
+				return healths, fmt.Errorf("error getting node health: %s", err)
+			}
+			healths[id] = health
+			if !(health.GotNN && health.KnowNN && health.Full) {
+				healthy = false
+			}
+		}
+		if healthy {
+			return healths, nil
 		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return healths, ctx.Err()
+		}
+	}
+}
+
+// ConnectError describes a single failed Net.Connect call made while
+// wiring up a Topology.
+type ConnectError struct {
+	A, B discover.NodeID
+	Err  error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("error connecting %s to %s: %s", e.A.TerminalString(), e.B.TerminalString(), e.Err)
+}
+
+// Connect wires up s.IDs according to topology, issuing the Net.Connect
+// calls concurrently. Any calls that fail are returned, so callers can
+// decide whether a partially connected topology is fatal.
+func (s *Simulation) Connect(topology Topology) []*ConnectError {
+	edges := topology.Edges(len(s.IDs))
+	var (
+		mu     sync.Mutex
+		failed []*ConnectError
+		wg     sync.WaitGroup
+	)
+	for _, edge := range edges {
+		wg.Add(1)
+		go func(a, b discover.NodeID) {
+			defer wg.Done()
+			if err := s.Net.Connect(a, b); err != nil {
+				mu.Lock()
+				failed = append(failed, &ConnectError{A: a, B: b, Err: err})
+				mu.Unlock()
+			}
+		}(s.IDs[edge[0]], s.IDs[edge[1]])
 	}
 	wg.Wait()
+	return failed
+}
+
+func (s *Simulation) Run(conf *RunConfig) (*simulations.StepResult, error) {
+	// bring up nodes, launch the servive
+	if conf.SnapshotPath == "" && conf.Snapshot == nil {
+		// Net.Load already starts and connects the nodes it restores,
+		// so only do this manually when we created fresh nodes above.
+		for _, id := range s.IDs {
+			if err := s.Net.Start(id); err != nil {
+				return nil, fmt.Errorf("error starting node %s: %s", id.TerminalString(), err)
+			}
+		}
+		topology := conf.Topology
+		if topology == nil {
+			topology = ChainTopology{}
+		}
+		if failed := s.Connect(topology); len(failed) > 0 {
+			return nil, fmt.Errorf("error connecting nodes: %s", failed[0])
+		}
+	}
 
 	log.Debug(fmt.Sprintf("nodes: %v", len(s.Addrs)))
 
@@ -203,6 +830,19 @@ func (s *Simulation) Run(conf *RunConfig) (*simulations.StepResult, error) {
 	timeout := 300 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+
+	if conf.WaitTillHealthy {
+		if _, err := s.WaitTillHealthy(ctx, conf.KadMinProxSize); err != nil {
+			return nil, fmt.Errorf("error waiting for healthy kademlia: %s", err)
+		}
+	}
+
+	s.monitor = newRunMonitor()
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	go s.monitor.watchEvents(monitorCtx, s.Net)
+	go s.monitor.sampleResources(monitorCtx)
+
 	result := simulations.NewSimulation(s.Net).Run(ctx, conf.Step)
+	stopMonitor()
 	return result, nil
 }
@@ -0,0 +1,409 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/ethereum/go-ethereum/swarm/storage/mock/mem"
+)
+
+func TestSimulationNodeItemRoundTrip(t *testing.T) {
+	s := &Simulation{buckets: make(map[discover.NodeID]*sync.Map)}
+	id := discover.NodeID{1}
+
+	if _, ok := s.NodeItem(id, "key"); ok {
+		t.Fatal("want ok=false before SetNodeItem was ever called")
+	}
+
+	s.SetNodeItem(id, "key", "value")
+	got, ok := s.NodeItem(id, "key")
+	if !ok || got != "value" {
+		t.Fatalf("got %v, %v; want \"value\", true", got, ok)
+	}
+
+	other := discover.NodeID{2}
+	if _, ok := s.NodeItem(other, "key"); ok {
+		t.Fatal("item set for one node leaked into another node's bucket")
+	}
+}
+
+func TestWrapServicesThreadsPerNodeBucket(t *testing.T) {
+	s := &Simulation{buckets: make(map[discover.NodeID]*sync.Map)}
+	id := discover.NodeID{3}
+	s.SetNodeItem(id, "marker", id)
+
+	var got *sync.Map
+	services := Services{
+		"noop": func(ctx *adapters.ServiceContext, bucket *sync.Map) (node.Service, error) {
+			got = bucket
+			return nil, nil
+		},
+	}
+	wrapped := wrapServices(services, s)
+	if _, err := wrapped["noop"](&adapters.ServiceContext{Config: &adapters.NodeConfig{ID: id}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := got.Load("marker"); !ok || v != id {
+		t.Fatal("wrapServices did not resolve the bucket belonging to the node being constructed")
+	}
+}
+
+// noIsolatedNodes fails t if any node in [0,n) is missing from edges, for
+// topologies that are expected to connect every node.
+func noIsolatedNodes(t *testing.T, n int, edges [][2]int) {
+	t.Helper()
+	seen := make([]bool, n)
+	for _, e := range edges {
+		seen[e[0]] = true
+		seen[e[1]] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			t.Errorf("node %d has no edges", i)
+		}
+	}
+}
+
+// noSelfLoops fails t if edges contains a pair connecting a node to itself.
+func noSelfLoops(t *testing.T, edges [][2]int) {
+	t.Helper()
+	for _, e := range edges {
+		if e[0] == e[1] {
+			t.Errorf("self-loop edge %v", e)
+		}
+	}
+}
+
+// noOutOfRange fails t if edges references a node index outside [0,n).
+func noOutOfRange(t *testing.T, n int, edges [][2]int) {
+	t.Helper()
+	for _, e := range edges {
+		if e[0] < 0 || e[0] >= n || e[1] < 0 || e[1] >= n {
+			t.Errorf("edge %v out of range for n=%d", e, n)
+		}
+	}
+}
+
+func TestChainTopologyEdges(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 5} {
+		edges := ChainTopology{}.Edges(n)
+		if n <= 1 {
+			if len(edges) != 0 {
+				t.Errorf("n=%d: want no edges, got %v", n, edges)
+			}
+			continue
+		}
+		if len(edges) != n-1 {
+			t.Errorf("n=%d: want %d edges, got %d", n, n-1, len(edges))
+		}
+		noSelfLoops(t, edges)
+		noOutOfRange(t, n, edges)
+		noIsolatedNodes(t, n, edges)
+	}
+}
+
+func TestRingTopologyEdges(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 6} {
+		edges := RingTopology{}.Edges(n)
+		noSelfLoops(t, edges)
+		noOutOfRange(t, n, edges)
+		if n > 2 {
+			noIsolatedNodes(t, n, edges)
+			if len(edges) != n {
+				t.Errorf("n=%d: want %d edges, got %d", n, n, len(edges))
+			}
+		}
+	}
+}
+
+func TestStarTopologyEdges(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 5} {
+		edges := StarTopology{}.Edges(n)
+		if n <= 1 {
+			if len(edges) != 0 {
+				t.Errorf("n=%d: want no edges, got %v", n, edges)
+			}
+			continue
+		}
+		if len(edges) != n-1 {
+			t.Errorf("n=%d: want %d edges, got %d", n, n-1, len(edges))
+		}
+		noSelfLoops(t, edges)
+		noOutOfRange(t, n, edges)
+		noIsolatedNodes(t, n, edges)
+	}
+}
+
+func TestGridTopologyEdges(t *testing.T) {
+	tests := []struct {
+		rows, cols, n int
+	}{
+		{2, 2, 4},
+		{2, 2, 7},
+		{1, 3, 3},
+		{3, 3, 9},
+	}
+	for _, tt := range tests {
+		topo := GridTopology{Rows: tt.rows, Cols: tt.cols}
+		edges := topo.Edges(tt.n)
+		noSelfLoops(t, edges)
+		noOutOfRange(t, tt.n, edges)
+		noIsolatedNodes(t, tt.n, edges)
+	}
+}
+
+func TestWattsStrogatzEdges(t *testing.T) {
+	tests := []struct {
+		n, k int
+		beta float64
+	}{
+		{0, 4, 0.5},
+		{1, 4, 0.5},
+		{2, 2, 0.5},
+		{2, 4, 0.5},
+		{3, 6, 0.5},
+		{10, 4, 0},
+		{10, 4, 1},
+		{10, 3, 0.3},
+	}
+	for _, tt := range tests {
+		topo := WattsStrogatz{K: tt.k, Beta: tt.beta}
+		edges := topo.Edges(tt.n)
+		if tt.n <= 1 {
+			if len(edges) != 0 {
+				t.Errorf("n=%d: want no edges, got %v", tt.n, edges)
+			}
+			continue
+		}
+		noSelfLoops(t, edges)
+		noOutOfRange(t, tt.n, edges)
+		if tt.k > 0 {
+			noIsolatedNodes(t, tt.n, edges)
+		}
+	}
+}
+
+func TestLoadSnapshotNone(t *testing.T) {
+	snap, err := loadSnapshot("", nil)
+	if err != nil || snap != nil {
+		t.Fatalf("got %v, %v; want nil, nil", snap, err)
+	}
+}
+
+func TestLoadSnapshotInline(t *testing.T) {
+	want := &simulations.Snapshot{}
+	got, err := loadSnapshot("", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatal("loadSnapshot did not return the inline snapshot unchanged")
+	}
+}
+
+func TestLoadSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-loadsnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snap.json")
+	if err := ioutil.WriteFile(path, []byte(`{"nodes":[],"conns":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadSnapshot(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("got nil snapshot for a valid snapshot file")
+	}
+}
+
+func TestLoadSnapshotFileMissing(t *testing.T) {
+	if _, err := loadSnapshot(filepath.Join(os.TempDir(), "testing-loadsnapshot-missing.json"), nil); err == nil {
+		t.Fatal("want error for a missing snapshot file")
+	}
+}
+
+func TestLoadSnapshotFileInvalidJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-loadsnapshot-invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snap.json")
+	if err := ioutil.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadSnapshot(path, nil); err == nil {
+		t.Fatal("want error for a snapshot file with invalid JSON")
+	}
+}
+
+func TestSetMemStoresRoundTrip(t *testing.T) {
+	addrs := []network.Addr{network.RandomAddr(), network.RandomAddr()}
+	stores, teardown, err := setMemStores(addrs...)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stores) != len(addrs) {
+		t.Fatalf("got %d stores, want %d", len(stores), len(addrs))
+	}
+
+	ch := storage.NewChunk(storage.Address(make([]byte, 32)), []byte("data"))
+	if err := stores[0].Put(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := stores[0].Get(context.Background(), ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatalf("got %x, want %x", got.Data(), ch.Data())
+	}
+
+	if _, err := stores[1].Get(context.Background(), ch.Address()); err == nil {
+		t.Fatal("chunk written to one node's mem store is visible from another node's store")
+	}
+}
+
+func TestSetMockStoresIsolatedPerNode(t *testing.T) {
+	addrs := []network.Addr{network.RandomAddr(), network.RandomAddr()}
+	global := mem.NewGlobalStore()
+	stores, teardown, err := setMockStores(global, addrs...)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stores) != len(addrs) {
+		t.Fatalf("got %d stores, want %d", len(stores), len(addrs))
+	}
+
+	ch := storage.NewChunk(storage.Address(make([]byte, 32)), []byte("data"))
+	if err := stores[0].Put(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := stores[0].Get(context.Background(), ch.Address())
+	if err != nil {
+		t.Fatalf("chunk not found in the node it was written to: %s", err)
+	}
+	if !bytes.Equal(got.Data(), ch.Data()) {
+		t.Fatalf("got %x, want %x", got.Data(), ch.Data())
+	}
+
+	if _, err := stores[1].Get(context.Background(), ch.Address()); err == nil {
+		t.Fatal("chunk written to one node's mock store is visible from another node keyed on the same global store")
+	}
+}
+
+func TestRunMonitorRecordConn(t *testing.T) {
+	m := newRunMonitor()
+	a := discover.NodeID{1}
+	b := discover.NodeID{2}
+
+	m.record(&simulations.Event{Type: simulations.EventTypeConn, Conn: &simulations.Conn{One: a, Other: b, Up: true}})
+	m.record(&simulations.Event{Type: simulations.EventTypeConn, Conn: &simulations.Conn{One: a, Other: b, Up: false}})
+
+	if len(m.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(m.events))
+	}
+	if m.events[0].Type != "connect" {
+		t.Errorf("got %q, want \"connect\"", m.events[0].Type)
+	}
+	if m.events[1].Type != "disconnect" {
+		t.Errorf("got %q, want \"disconnect\"", m.events[1].Type)
+	}
+}
+
+func TestRunMonitorRecordMsg(t *testing.T) {
+	m := newRunMonitor()
+	a := discover.NodeID{1}
+	b := discover.NodeID{2}
+
+	m.record(&simulations.Event{Type: simulations.EventTypeMsg, Msg: &simulations.Msg{One: a, Other: b, Code: 3}})
+	m.record(&simulations.Event{Type: simulations.EventTypeMsg, Msg: &simulations.Msg{One: a, Other: b, Code: 3}})
+	m.record(&simulations.Event{Type: simulations.EventTypeMsg, Msg: &simulations.Msg{One: a, Other: b, Code: 7}})
+
+	if len(m.events) != 3 {
+		t.Fatalf("got %d events, want 3", len(m.events))
+	}
+	if m.events[0].Type != "msg" {
+		t.Errorf("got %q, want \"msg\"", m.events[0].Type)
+	}
+	if got := m.messageCounts[3]; got != 2 {
+		t.Errorf("got %d messages with code 3, want 2", got)
+	}
+	if got := m.messageCounts[7]; got != 1 {
+		t.Errorf("got %d messages with code 7, want 1", got)
+	}
+}
+
+func TestRunMonitorRecordIgnoresOtherTypes(t *testing.T) {
+	m := newRunMonitor()
+	m.record(&simulations.Event{Type: simulations.EventTypeNode})
+	if len(m.events) != 0 {
+		t.Fatalf("got %d events, want 0", len(m.events))
+	}
+}
+
+func TestRunMonitorReport(t *testing.T) {
+	m := newRunMonitor()
+	a := discover.NodeID{1}
+	m.record(&simulations.Event{Type: simulations.EventTypeConn, Conn: &simulations.Conn{One: a, Other: a, Up: true}})
+
+	started := time.Now()
+	result := &simulations.StepResult{
+		StartedAt: started,
+		Passes: map[discover.NodeID]time.Time{
+			a: started.Add(time.Second),
+		},
+	}
+	report := m.report(1, result, started, started.Add(2*time.Second))
+
+	if report.NodeCount != 1 {
+		t.Errorf("got NodeCount %d, want 1", report.NodeCount)
+	}
+	if len(report.Events) != 1 {
+		t.Errorf("got %d events in report, want 1", len(report.Events))
+	}
+	if report.Min != time.Second || report.Max != time.Second {
+		t.Errorf("got min=%s max=%s, want both %s", report.Min, report.Max, time.Second)
+	}
+	if report.Avg != time.Second {
+		t.Errorf("got avg=%s, want %s", report.Avg, time.Second)
+	}
+}